@@ -11,14 +11,29 @@ package main
 import "C"
 import (
 	"bytes"
+	"compress/gzip"
+	"container/list"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jmespath/go-jmespath"
+	"github.com/klauspost/compress/zstd"
 	"github.com/ldegio/libsinsp-plugin-sdk-go/pkg/sinsp"
+	"github.com/ohler55/ojg/jp"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/valyala/fastjson"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 // Plugin info
@@ -31,11 +46,688 @@ const (
 const verbose bool = false
 const outBufSize uint32 = 65535
 
+// Maximum number of compiled jsonpath/jmespath expressions kept around, per
+// language, to avoid recompiling the same arg on every event.
+const compiledExprCacheSize = 128
+
 type pluginContext struct {
-	jparser     fastjson.Parser
-	jdata       *fastjson.Value
-	jdataEvtnum uint64 // The event number jdata refers to. Used to know when we can skip the unmarshaling.
-	lastError   error
+	jparser  fastjson.Parser
+	ev       eventData
+	evEvtnum uint64 // The event number ev refers to. Used to know when we can skip re-decoding.
+
+	lastError error
+
+	jsonpathCache *exprCache
+	jmespathCache *exprCache
+
+	// format/compression select how raw event buffers are turned into JSON
+	// before extraction; see decodeEvent. Both default to "no-op" (plain JSON,
+	// uncompressed) when left unset in the plugin_init config.
+	format      string
+	compression string
+
+	// schema, when non-nil, is the compiled JSON Schema that every event is
+	// validated against for the jevt.schema.* fields.
+	schema *jsonschema.Schema
+
+	// Worker pool used to parallelize extraction across concurrent calls into
+	// plugin_extract_str. Disabled (workerPoolSize == 0) unless the config
+	// passed to plugin_init sets "worker_pool_size".
+	workerPoolSize int
+	jobs           chan *extractJob
+	parserPool     sync.Pool
+	jdataCache     *exprCache // keyed by evtnum, shared by the pooled path only
+	jdataGroup     *eventDataGroup
+	stats          poolStats
+}
+
+// eventData bundles everything extractField needs that's derived once per
+// event, regardless of whether it came from the sync or the pooled path.
+type eventData struct {
+	jdata *fastjson.Value
+	json  string
+	lines []string // split NDJSON records, nil unless format == "ndjson"
+
+	schemaValid  bool
+	schemaErrors map[string]string // first error message by JSON Pointer
+
+	// jdataMu guards jdata. fastjson.Value lazily mutates its own fields on
+	// first access (e.g. unescaping a raw string in Type()), so navigating
+	// one shared jdata from several worker goroutines at once - exactly what
+	// the pooled path does for the several fields extracted per event - is
+	// not safe without serializing those reads.
+	jdataMu *sync.Mutex
+}
+
+// Supported values for the "format" and "compression" config keys.
+const (
+	formatJSON    = "json"
+	formatNDJSON  = "ndjson"
+	formatCBOR    = "cbor"
+	formatMsgPack = "msgpack"
+
+	compressionNone = ""
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// pluginConfig is the JSON object accepted as the plugin_init config string.
+type pluginConfig struct {
+	WorkerPoolSize int    `json:"worker_pool_size"`
+	Format         string `json:"format"`
+	Compression    string `json:"compression"`
+	Schema         string `json:"schema"`      // inline JSON Schema document
+	SchemaPath     string `json:"schema_path"` // path to a JSON Schema document; wins over Schema if both are set
+}
+
+// poolStats holds the counters backing the jevt.stats field. All fields are
+// updated with the sync/atomic package since they're touched from every
+// worker goroutine plus the calling thread.
+type poolStats struct {
+	queued     int64 // jobs currently sitting in the channel
+	dropped    int64 // jobs that found the channel full and ran synchronously instead
+	parseNanos int64 // cumulative time spent parsing JSON in parseEventData, on whichever goroutine runs it
+	parseCount int64 // number of parseEventData parses, for the average
+}
+
+// extractJob is a unit of work submitted to the worker pool: extract field
+// id/arg from the JSON payload of one event.
+type extractJob struct {
+	evtnum  uint64
+	id      uint32
+	arg     string
+	data    string
+	resultC chan extractResult
+}
+
+type extractResult struct {
+	value string
+	err   error
+}
+
+// jdataCacheEntry is what the pooled path stores in jdataCache: the fully
+// derived eventData plus the parser that produced its jdata. fastjson
+// invalidates previously parsed values the next time the same
+// *fastjson.Parser parses again, so the parser must not be recycled into
+// parserPool while any goroutine still holds this entry - being LRU-evicted
+// is not enough on its own, since another worker can still be mid-read of
+// ev.jdata at that exact moment. refs tracks those outstanding holders;
+// evict only recycles the parser once refs has dropped back to zero.
+type jdataCacheEntry struct {
+	ev     eventData
+	parser *fastjson.Parser
+
+	mu      sync.Mutex
+	refs    int
+	evicted bool
+}
+
+// acquire registers the calling goroutine as a holder of e, deferring any
+// eviction-triggered recycling of e.parser until it releases.
+func (e *jdataCacheEntry) acquire() {
+	e.mu.Lock()
+	e.refs++
+	e.mu.Unlock()
+}
+
+// release drops a hold acquired via acquire, recycling e.parser into pool
+// if e has since been evicted and this was the last outstanding hold.
+func (e *jdataCacheEntry) release(pool *sync.Pool) {
+	e.mu.Lock()
+	e.refs--
+	recycle := e.evicted && e.refs <= 0
+	e.mu.Unlock()
+	if recycle {
+		pool.Put(e.parser)
+	}
+}
+
+// evict marks e as evicted from jdataCache, recycling e.parser into pool
+// immediately if nothing still holds e.
+func (e *jdataCacheEntry) evict(pool *sync.Pool) {
+	e.mu.Lock()
+	e.evicted = true
+	recycle := e.refs <= 0
+	e.mu.Unlock()
+	if recycle {
+		pool.Put(e.parser)
+	}
+}
+
+// eventDataGroup collapses concurrent cachedEventData misses for the same
+// evtnum into a single decode+parse, so that the several field extractions
+// libsinsp fans out per event don't each pay (and each leak a parser into)
+// their own independent parse of the same payload.
+type eventDataGroup struct {
+	mu    sync.Mutex
+	calls map[string]*inflightEventData
+}
+
+type inflightEventData struct {
+	done  chan struct{}
+	entry *jdataCacheEntry
+	err   error
+}
+
+func newEventDataGroup() *eventDataGroup {
+	return &eventDataGroup{calls: make(map[string]*inflightEventData)}
+}
+
+// do runs fn for key, sharing the result with any other goroutine that calls
+// do for the same key while fn is in flight. fn must return an entry that
+// already carries a hold acquired on behalf of the caller that produced it
+// (the winner); every other caller waiting on that same key acquires its
+// own hold here before do returns, so the entry can't be recycled out from
+// under either of them.
+func (g *eventDataGroup) do(key string, fn func() (*jdataCacheEntry, error)) (*jdataCacheEntry, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		if c.entry != nil {
+			c.entry.acquire()
+		}
+		return c.entry, c.err
+	}
+
+	c := &inflightEventData{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.entry, c.err = fn()
+	close(c.done)
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.entry, c.err
+}
+
+// decodedEvent is the result of turning a raw event buffer into the JSON
+// text (and, for NDJSON, the individual lines) that the rest of the
+// extractor operates on.
+type decodedEvent struct {
+	json  string
+	lines []string // populated only when format == "ndjson"
+}
+
+// decodeEvent undoes the configured compression framing and input format,
+// producing the JSON document(s) that the rest of the plugin understands.
+func (pCtx *pluginContext) decodeEvent(raw []byte) (decodedEvent, error) {
+	raw, err := pCtx.unwrapCompression(raw)
+	if err != nil {
+		return decodedEvent{}, err
+	}
+
+	switch pCtx.format {
+	case formatNDJSON:
+		lines := splitNDJSONLines(raw)
+		if len(lines) == 0 {
+			return decodedEvent{}, errFieldNotFound
+		}
+		return decodedEvent{json: lines[0], lines: lines}, nil
+	case formatCBOR:
+		j, err := toJSON(raw, cborDecMode.Unmarshal)
+		if err != nil {
+			return decodedEvent{}, err
+		}
+		return decodedEvent{json: j}, nil
+	case formatMsgPack:
+		j, err := toJSON(raw, msgpack.Unmarshal)
+		if err != nil {
+			return decodedEvent{}, err
+		}
+		return decodedEvent{json: j}, nil
+	default:
+		return decodedEvent{json: string(raw)}, nil
+	}
+}
+
+// unwrapCompression strips the configured compression framing, if any.
+func (pCtx *pluginContext) unwrapCompression(raw []byte) ([]byte, error) {
+	switch pCtx.compression {
+	case compressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return io.ReadAll(gr)
+	case compressionZstd:
+		zr, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return zr.DecodeAll(raw, nil)
+	default:
+		return raw, nil
+	}
+}
+
+// splitNDJSONLines splits a newline-delimited-JSON buffer into its
+// individual (non-empty) JSON documents.
+func splitNDJSONLines(raw []byte) []string {
+	rawLines := bytes.Split(raw, []byte("\n"))
+	lines := make([]string, 0, len(rawLines))
+	for _, l := range rawLines {
+		l = bytes.TrimRight(l, "\r")
+		if len(bytes.TrimSpace(l)) == 0 {
+			continue
+		}
+		lines = append(lines, string(l))
+	}
+	return lines
+}
+
+// cborDecMode decodes CBOR maps into map[string]interface{} rather than the
+// default map[interface{}]interface{}, which encoding/json cannot marshal.
+// Built once at package init since DecMode() is immutable and safe to share.
+var cborDecMode = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+// toJSON decodes raw with unmarshal into a generic Go value and re-encodes
+// it as JSON, so that CBOR/MessagePack payloads can be handled by the same
+// fastjson-based extraction as native JSON input.
+func toJSON(raw []byte, unmarshal func([]byte, interface{}) error) (string, error) {
+	var v interface{}
+	if err := unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// loadSchema compiles the JSON Schema named by the plugin config, if any.
+// cfg.SchemaPath wins over an inline cfg.Schema when both are set. Returns a
+// nil schema (and a nil error) when neither is configured.
+func loadSchema(cfg pluginConfig) (*jsonschema.Schema, error) {
+	schemaText := cfg.Schema
+	if cfg.SchemaPath != "" {
+		b, err := ioutil.ReadFile(cfg.SchemaPath)
+		if err != nil {
+			return nil, err
+		}
+		schemaText = string(b)
+	}
+	if schemaText == "" {
+		return nil, nil
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("jevt-schema.json", strings.NewReader(schemaText)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("jevt-schema.json")
+}
+
+// validateSchema validates jdata against pCtx.schema, if one is configured.
+// With no schema configured, every event is trivially valid.
+func (pCtx *pluginContext) validateSchema(jdata *fastjson.Value) (bool, map[string]string) {
+	if pCtx.schema == nil {
+		return true, nil
+	}
+
+	err := pCtx.schema.Validate(fastjsonToInterface(jdata))
+	if err == nil {
+		return true, nil
+	}
+
+	errs := make(map[string]string)
+	collectSchemaErrors(err, errs)
+	return false, errs
+}
+
+// collectSchemaErrors flattens a (possibly nested) jsonschema.ValidationError
+// into a map of JSON Pointer -> first error message at that pointer.
+func collectSchemaErrors(err error, out map[string]string) {
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return
+	}
+
+	if len(ve.Causes) == 0 {
+		ptr := ve.InstanceLocation
+		if ptr == "" {
+			ptr = "/"
+		}
+		if _, exists := out[ptr]; !exists {
+			out[ptr] = ve.Message
+		}
+		return
+	}
+	for _, cause := range ve.Causes {
+		collectSchemaErrors(cause, out)
+	}
+}
+
+// startWorkerPool spins up size extraction workers and wires jdataCache to
+// recycle their parsers back into parserPool on eviction.
+func (pCtx *pluginContext) startWorkerPool(size int) {
+	pCtx.workerPoolSize = size
+	pCtx.jobs = make(chan *extractJob, size*4)
+	pCtx.parserPool.New = func() interface{} { return &fastjson.Parser{} }
+	pCtx.jdataCache = newExprCache(size * 4)
+	pCtx.jdataCache.onEvict = func(_ string, value interface{}) {
+		value.(*jdataCacheEntry).evict(&pCtx.parserPool)
+	}
+	pCtx.jdataGroup = newEventDataGroup()
+
+	for i := 0; i < size; i++ {
+		go pCtx.extractWorker()
+	}
+}
+
+func (pCtx *pluginContext) extractWorker() {
+	for job := range pCtx.jobs {
+		atomic.AddInt64(&pCtx.stats.queued, -1)
+		job.resultC <- pCtx.runExtract(job.evtnum, job.id, job.arg, job.data)
+	}
+}
+
+// runExtract parses (or reuses the cached parse of) the event's JSON and
+// extracts the requested field from it. It is safe to call concurrently
+// from multiple workers.
+func (pCtx *pluginContext) runExtract(evtnum uint64, id uint32, sarg string, sdata string) extractResult {
+	ev, release, err := pCtx.cachedEventData(evtnum, sdata)
+	if err != nil {
+		return extractResult{err: err}
+	}
+	defer release()
+
+	val, err := pCtx.extractField(ev, id, sarg)
+	return extractResult{value: val, err: err}
+}
+
+// cachedEventData returns the already-decoded/parsed/validated data for
+// evtnum, computing it (with a parser borrowed from parserPool) the first
+// time it's requested. Concurrent misses for the same evtnum - the common
+// case, since libsinsp fans out several field extractions per event - are
+// collapsed into a single decode+parse via jdataGroup instead of racing.
+//
+// The returned release func must be called once the caller is done reading
+// the returned eventData's jdata; until then, it holds the underlying
+// jdataCacheEntry and its parser can't be recycled even if the entry is
+// LRU-evicted out from under it in the meantime.
+func (pCtx *pluginContext) cachedEventData(evtnum uint64, sdata string) (eventData, func(), error) {
+	key := strconv.FormatUint(evtnum, 10)
+	if v, _, ok := pCtx.jdataCache.get(key); ok {
+		entry := v.(*jdataCacheEntry)
+		entry.acquire()
+		return entry.ev, func() { entry.release(&pCtx.parserPool) }, nil
+	}
+
+	entry, err := pCtx.jdataGroup.do(key, func() (*jdataCacheEntry, error) {
+		// Another caller may have populated the cache while we were
+		// waiting for the in-flight slot for key.
+		if v, _, ok := pCtx.jdataCache.get(key); ok {
+			entry := v.(*jdataCacheEntry)
+			entry.acquire()
+			return entry, nil
+		}
+		return pCtx.parseEventData(key, sdata)
+	})
+	if err != nil {
+		return eventData{}, func() {}, err
+	}
+	return entry.ev, func() { entry.release(&pCtx.parserPool) }, nil
+}
+
+// parseEventData decodes and parses sdata and stores the result in
+// jdataCache under key. Only ever called once in-flight per key, via
+// jdataGroup.do. The returned entry carries one hold, acquired before it is
+// published to jdataCache, on behalf of the caller that triggered the parse.
+func (pCtx *pluginContext) parseEventData(key, sdata string) (*jdataCacheEntry, error) {
+	dec, err := pCtx.decodeEvent([]byte(sdata))
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	parser := pCtx.parserPool.Get().(*fastjson.Parser)
+	jdata, err := parser.Parse(dec.json)
+	atomic.AddInt64(&pCtx.stats.parseNanos, time.Since(start).Nanoseconds())
+	atomic.AddInt64(&pCtx.stats.parseCount, 1)
+	if err != nil {
+		pCtx.parserPool.Put(parser)
+		return nil, errFieldNotFound
+	}
+
+	ev := eventData{jdata: jdata, json: dec.json, lines: dec.lines, jdataMu: &sync.Mutex{}}
+	ev.schemaValid, ev.schemaErrors = pCtx.validateSchema(jdata)
+
+	entry := &jdataCacheEntry{ev: ev, parser: parser}
+	entry.acquire()
+	pCtx.jdataCache.put(key, entry, nil)
+	return entry, nil
+}
+
+// extractPooled submits an extraction job to the worker pool, falling back
+// to a synchronous extraction if the pool's job queue is saturated so that
+// callers never block indefinitely.
+func (pCtx *pluginContext) extractPooled(evtnum uint64, id uint32, sarg, sdata string) (string, error) {
+	job := &extractJob{evtnum: evtnum, id: id, arg: sarg, data: sdata, resultC: make(chan extractResult, 1)}
+
+	select {
+	case pCtx.jobs <- job:
+		atomic.AddInt64(&pCtx.stats.queued, 1)
+		res := <-job.resultC
+		return res.value, res.err
+	default:
+		// Queue saturated: run on the calling goroutine instead of blocking
+		// it. Safe to call concurrently, unlike extractSync.
+		atomic.AddInt64(&pCtx.stats.dropped, 1)
+		res := pCtx.runExtract(evtnum, id, sarg, sdata)
+		return res.value, res.err
+	}
+}
+
+// statsString renders the current pool metrics for the jevt.stats field.
+func (pCtx *pluginContext) statsString() string {
+	parseCount := atomic.LoadInt64(&pCtx.stats.parseCount)
+	var avgParseUs int64
+	if parseCount > 0 {
+		avgParseUs = atomic.LoadInt64(&pCtx.stats.parseNanos) / parseCount / 1000
+	}
+
+	return fmt.Sprintf("queue_depth=%d dropped=%d avg_parse_us=%d",
+		atomic.LoadInt64(&pCtx.stats.queued), atomic.LoadInt64(&pCtx.stats.dropped), avgParseUs)
+}
+
+// exprCache is a small bounded LRU used to cache compiled jsonpath/jmespath
+// expressions keyed by their (normalized) source text, so that a rule
+// referencing the same arg over and over does not pay recompilation cost on
+// every event.
+type exprCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	// onEvict, if set, is called with the value of an entry evicted to make
+	// room for a new one, or replaced by a put of a different value under the
+	// same key, e.g. to return a pooled resource it owns.
+	onEvict func(key string, value interface{})
+}
+
+type exprCacheEntry struct {
+	key   string
+	value interface{}
+	err   error
+}
+
+func newExprCache(capacity int) *exprCache {
+	return &exprCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *exprCache) get(key string) (interface{}, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(el)
+	entry := el.Value.(*exprCacheEntry)
+	return entry.value, entry.err, true
+}
+
+func (c *exprCache) put(key string, value interface{}, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*exprCacheEntry)
+		old := entry.value
+		entry.value = value
+		entry.err = err
+		c.order.MoveToFront(el)
+		if c.onEvict != nil && old != nil && old != value {
+			c.onEvict(key, old)
+		}
+		return
+	}
+
+	el := c.order.PushFront(&exprCacheEntry{key: key, value: value, err: err})
+	c.items[key] = el
+
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			evicted := oldest.Value.(*exprCacheEntry)
+			delete(c.items, evicted.key)
+			if c.onEvict != nil {
+				c.onEvict(evicted.key, evicted.value)
+			}
+		}
+	}
+}
+
+// normalizeExprArg trims incidental whitespace from a field arg so that
+// e.g. "jevt.jsonpath[ $.foo ]" and "jevt.jsonpath[$.foo]" share one cache
+// entry instead of two.
+func normalizeExprArg(arg string) string {
+	return strings.TrimSpace(arg)
+}
+
+// compiledJSONPath returns the compiled jsonpath expression for sarg,
+// compiling and caching it on first use.
+func (pCtx *pluginContext) compiledJSONPath(sarg string) (jp.Expr, error) {
+	if v, err, ok := pCtx.jsonpathCache.get(sarg); ok {
+		if err != nil {
+			return nil, err
+		}
+		return v.(jp.Expr), nil
+	}
+
+	expr, err := jp.ParseString(sarg)
+	pCtx.jsonpathCache.put(sarg, expr, err)
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// compiledJMESPath returns the compiled jmespath expression for sarg,
+// compiling and caching it on first use.
+func (pCtx *pluginContext) compiledJMESPath(sarg string) (*jmespath.JMESPath, error) {
+	if v, err, ok := pCtx.jmespathCache.get(sarg); ok {
+		if err != nil {
+			return nil, err
+		}
+		return v.(*jmespath.JMESPath), nil
+	}
+
+	expr, err := jmespath.Compile(sarg)
+	pCtx.jmespathCache.put(sarg, expr, err)
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// fastjsonToInterface converts a fastjson.Value into the plain
+// map[string]interface{}/[]interface{}/scalar tree that the jsonpath and
+// jmespath libraries operate on.
+func fastjsonToInterface(v *fastjson.Value) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	switch v.Type() {
+	case fastjson.TypeObject:
+		obj, err := v.Object()
+		if err != nil {
+			return nil
+		}
+		m := make(map[string]interface{})
+		obj.Visit(func(key []byte, vv *fastjson.Value) {
+			m[string(key)] = fastjsonToInterface(vv)
+		})
+		return m
+	case fastjson.TypeArray:
+		arr, err := v.Array()
+		if err != nil {
+			return nil
+		}
+		a := make([]interface{}, len(arr))
+		for i, vv := range arr {
+			a[i] = fastjsonToInterface(vv)
+		}
+		return a
+	case fastjson.TypeString:
+		sb, _ := v.StringBytes()
+		return string(sb)
+	case fastjson.TypeNumber:
+		return v.GetFloat64()
+	case fastjson.TypeTrue:
+		return true
+	case fastjson.TypeFalse:
+		return false
+	default:
+		return nil
+	}
+}
+
+// errFieldNotFound is returned by extractField when the requested value is
+// legitimately absent (as opposed to a parse/compile error), so callers can
+// tell that apart from an empty-string match.
+var errFieldNotFound = fmt.Errorf("field not found")
+
+// resultToString renders a jsonpath/jmespath match as the string that gets
+// handed back to sinsp: scalars are rendered directly, anything else
+// (objects, arrays) is serialized as compact JSON.
+func resultToString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", errFieldNotFound
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
 }
 
 //export plugin_get_type
@@ -61,7 +753,40 @@ func plugin_init(config *C.char, rc *int32) unsafe.Pointer {
 	sinsp.MakeBuffer(pluginState, outBufSize)
 
 	// Allocate the context struct and set it to the state
-	pCtx := &pluginContext{}
+	pCtx := &pluginContext{
+		jsonpathCache: newExprCache(compiledExprCacheSize),
+		jmespathCache: newExprCache(compiledExprCacheSize),
+	}
+
+	var cfg pluginConfig
+	if confStr := C.GoString(config); confStr != "" {
+		if err := json.Unmarshal([]byte(confStr), &cfg); err != nil {
+			log.Printf("[%s] could not parse config, worker pool disabled: %s\n", PluginName, err)
+		}
+	}
+	if cfg.WorkerPoolSize > 0 {
+		pCtx.startWorkerPool(cfg.WorkerPoolSize)
+	}
+
+	switch cfg.Format {
+	case "", formatJSON, formatNDJSON, formatCBOR, formatMsgPack:
+		pCtx.format = cfg.Format
+	default:
+		log.Printf("[%s] unknown format %q, defaulting to json\n", PluginName, cfg.Format)
+	}
+	switch cfg.Compression {
+	case "", compressionGzip, compressionZstd:
+		pCtx.compression = cfg.Compression
+	default:
+		log.Printf("[%s] unknown compression %q, disabling decompression\n", PluginName, cfg.Compression)
+	}
+
+	if schema, err := loadSchema(cfg); err != nil {
+		log.Printf("[%s] could not compile schema, jevt.schema.* disabled: %s\n", PluginName, err)
+	} else {
+		pCtx.schema = schema
+	}
+
 	sinsp.SetContext(pluginState, unsafe.Pointer(pCtx))
 
 	*rc = sinsp.ScapSuccess
@@ -108,6 +833,15 @@ func plugin_get_required_api_version() *C.char {
 const (
 	FieldIDValue = iota
 	FieldIDMsg
+	FieldIDJSONPath
+	FieldIDJMESPath
+	FieldIDStats
+	FieldIDLine
+	FieldIDSchemaValid
+	FieldIDSchemaErrors
+	FieldIDNum
+	FieldIDBool
+	FieldIDTime
 )
 
 //export plugin_get_fields
@@ -115,6 +849,15 @@ func plugin_get_fields() *C.char {
 	flds := []sinsp.FieldEntry{
 		{Type: "string", ID: FieldIDValue, Name: "jevt.value", Desc: "allows to extract a value from a JSON-encoded input. Syntax is jevt.value[/x/y/z], where x,y and z are levels in the JSON hierarchy."},
 		{Type: "string", ID: FieldIDMsg, Name: "jevt.json", Desc: "the full json message as a text string."},
+		{Type: "string", ID: FieldIDJSONPath, Name: "jevt.jsonpath", Desc: "allows to extract a value from a JSON-encoded input using a JSONPath expression, e.g. jevt.jsonpath[$.requestObject.spec.containers[0].name]. Non-scalar matches are returned as compact JSON."},
+		{Type: "string", ID: FieldIDJMESPath, Name: "jevt.jmespath", Desc: "allows to extract a value from a JSON-encoded input using a JMESPath expression, e.g. jevt.jmespath[requestObject.spec.containers[0].name]. Non-scalar matches are returned as compact JSON."},
+		{Type: "string", ID: FieldIDStats, Name: "jevt.stats", Desc: "reports the async extractor worker pool metrics as 'queue_depth=N dropped=N avg_parse_us=N'. Always \"queue_depth=0 dropped=0 avg_parse_us=0\" when worker_pool_size is not set in the plugin config."},
+		{Type: "string", ID: FieldIDLine, Name: "jevt.line", Desc: "addresses a single record by 0-based index when format is \"ndjson\", e.g. jevt.line[2]. All other fields (jevt.value, jevt.jsonpath, ...) operate on line 0."},
+		{Type: "string", ID: FieldIDSchemaValid, Name: "jevt.schema.valid", Desc: "\"true\" or \"false\" depending on whether the event validates against the JSON Schema configured via \"schema\"/\"schema_path\" in the plugin config. Always \"true\" when no schema is configured."},
+		{Type: "string", ID: FieldIDSchemaErrors, Name: "jevt.schema.errors", Desc: "the first JSON Schema validation error at the given JSON Pointer, e.g. jevt.schema.errors[/spec/containers/0/image]."},
+		{Type: "uint64", ID: FieldIDNum, Name: "jevt.num", Desc: "like jevt.value, but returns the value as a number, allowing range comparisons such as jevt.num[/response/code] >= 500."},
+		{Type: "bool", ID: FieldIDBool, Name: "jevt.bool", Desc: "like jevt.value, but returns the value as a boolean."},
+		{Type: "abstime", ID: FieldIDTime, Name: "jevt.time", Desc: "like jevt.value, but parses the value as an RFC3339 timestamp and returns it as nanoseconds since the epoch."},
 	}
 
 	b, err := json.Marshal(&flds)
@@ -126,47 +869,206 @@ func plugin_get_fields() *C.char {
 	return C.CString(string(b))
 }
 
-//export plugin_extract_str
-func plugin_extract_str(plgState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) *byte {
-	var res string
-	var err error
-	pCtx := (*pluginContext)(sinsp.Context(plgState))
-
-	// Decode the json, but only if we haven't done it yet for this event
-	if evtnum != pCtx.jdataEvtnum {
-		pCtx.jdata, err = pCtx.jparser.Parse(C.GoString((*C.char)(unsafe.Pointer(data))))
-		if err != nil {
-			//
-			// Not a json file. We return nil to indicate that the field is not
-			// present.
-			//
-			return nil
-		}
-		pCtx.jdataEvtnum = evtnum
-	}
-
+// extractField evaluates field id/sarg against an already-decoded event. It
+// holds no pluginContext state of its own beyond the compiled-expression
+// caches, so it's safe to call from any goroutine.
+func (pCtx *pluginContext) extractField(ev eventData, id uint32, sarg string) (string, error) {
 	switch id {
 	case FieldIDValue:
-		sarg := C.GoString((*C.char)(unsafe.Pointer(arg)))
 		if sarg[0] == '/' {
 			sarg = sarg[1:]
 		}
 		hc := strings.Split(sarg, "/")
 
-		val := pCtx.jdata.GetStringBytes(hc...)
+		ev.jdataMu.Lock()
+		val := ev.jdata.GetStringBytes(hc...)
+		ev.jdataMu.Unlock()
 		if val == nil {
-			return nil
+			return "", errFieldNotFound
 		}
-		res = string(val)
+		return string(val), nil
 	case FieldIDMsg:
 		var out bytes.Buffer
-		err = json.Indent(&out, []byte(C.GoString((*C.char)(unsafe.Pointer(data)))), "", "  ")
+		if err := json.Indent(&out, []byte(ev.json), "", "  "); err != nil {
+			return "", errFieldNotFound
+		}
+		return out.String(), nil
+	case FieldIDLine:
+		n, err := strconv.Atoi(sarg)
+		if err != nil || n < 0 || n >= len(ev.lines) {
+			return "", errFieldNotFound
+		}
+		return ev.lines[n], nil
+	case FieldIDJSONPath:
+		sarg = normalizeExprArg(sarg)
+		expr, err := pCtx.compiledJSONPath(sarg)
 		if err != nil {
-			return nil
+			return "", err
 		}
-		res = string(out.Bytes())
+
+		ev.jdataMu.Lock()
+		data := fastjsonToInterface(ev.jdata)
+		ev.jdataMu.Unlock()
+		match, found := expr.FirstFound(data)
+		if !found {
+			return "", errFieldNotFound
+		}
+		return resultToString(match)
+	case FieldIDJMESPath:
+		sarg = normalizeExprArg(sarg)
+		expr, err := pCtx.compiledJMESPath(sarg)
+		if err != nil {
+			return "", err
+		}
+
+		ev.jdataMu.Lock()
+		data := fastjsonToInterface(ev.jdata)
+		ev.jdataMu.Unlock()
+		match, err := expr.Search(data)
+		if err != nil {
+			return "", err
+		}
+		return resultToString(match)
+	case FieldIDSchemaValid:
+		return strconv.FormatBool(ev.schemaValid), nil
+	case FieldIDSchemaErrors:
+		msg, ok := ev.schemaErrors[sarg]
+		if !ok {
+			return "", errFieldNotFound
+		}
+		return msg, nil
+	default:
+		return "<NA>", nil
+	}
+}
+
+// syncEventData decodes, parses and validates the event's JSON at most once
+// per evtnum, caching the result in pCtx.ev/evEvtnum. Not safe to call
+// concurrently; only used when the worker pool is disabled (the pool's
+// saturation fallback goes through runExtract instead).
+func (pCtx *pluginContext) syncEventData(evtnum uint64, sdata string) (eventData, error) {
+	if evtnum == pCtx.evEvtnum {
+		return pCtx.ev, nil
+	}
+
+	dec, err := pCtx.decodeEvent([]byte(sdata))
+	if err != nil {
+		return eventData{}, err
+	}
+
+	jdata, err := pCtx.jparser.Parse(dec.json)
+	if err != nil {
+		// Not a json file. Treat the field as absent rather than erroring.
+		return eventData{}, errFieldNotFound
+	}
+
+	ev := eventData{jdata: jdata, json: dec.json, lines: dec.lines, jdataMu: &sync.Mutex{}}
+	ev.schemaValid, ev.schemaErrors = pCtx.validateSchema(jdata)
+	pCtx.ev = ev
+	pCtx.evEvtnum = evtnum
+	return ev, nil
+}
+
+// extractSync is the string-typed extraction path built on syncEventData.
+func (pCtx *pluginContext) extractSync(evtnum uint64, id uint32, sarg, sdata string) (string, error) {
+	ev, err := pCtx.syncEventData(evtnum, sdata)
+	if err != nil {
+		return "", err
+	}
+	return pCtx.extractField(ev, id, sarg)
+}
+
+// eventDataFor returns the decoded/parsed/validated eventData for evtnum,
+// through the pooled path if a worker pool is configured and the
+// non-concurrent-safe single-slot path otherwise. Shared by every typed and
+// string extractor. The returned release func must be called once the
+// caller is done reading the returned eventData's jdata; it is a no-op on
+// the non-pooled path.
+func (pCtx *pluginContext) eventDataFor(evtnum uint64, sdata string) (eventData, func(), error) {
+	if pCtx.workerPoolSize > 0 {
+		return pCtx.cachedEventData(evtnum, sdata)
+	}
+	ev, err := pCtx.syncEventData(evtnum, sdata)
+	return ev, func() {}, err
+}
+
+// valueAt walks jdata along the same slash-delimited path syntax as
+// jevt.value/jevt.num/jevt.bool/jevt.time.
+func valueAt(jdata *fastjson.Value, sarg string) *fastjson.Value {
+	if len(sarg) > 0 && sarg[0] == '/' {
+		sarg = sarg[1:]
+	}
+	return jdata.Get(strings.Split(sarg, "/")...)
+}
+
+// extractInt64At returns the number at sarg, if any, as an int64.
+func extractInt64At(jdata *fastjson.Value, sarg string) (int64, bool) {
+	v := valueAt(jdata, sarg)
+	if v == nil {
+		return 0, false
+	}
+	n, err := v.Int64()
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// extractBoolAt returns the boolean at sarg, if any.
+func extractBoolAt(jdata *fastjson.Value, sarg string) (bool, bool) {
+	v := valueAt(jdata, sarg)
+	if v == nil {
+		return false, false
+	}
+	switch v.Type() {
+	case fastjson.TypeTrue:
+		return true, true
+	case fastjson.TypeFalse:
+		return false, true
 	default:
-		res = "<NA>"
+		return false, false
+	}
+}
+
+// extractTimeAt parses the RFC3339 timestamp string at sarg, if any, into
+// nanoseconds since the epoch.
+func extractTimeAt(jdata *fastjson.Value, sarg string) (uint64, bool) {
+	v := valueAt(jdata, sarg)
+	if v == nil {
+		return 0, false
+	}
+	sb, err := v.StringBytes()
+	if err != nil {
+		return 0, false
+	}
+	t, err := time.Parse(time.RFC3339, string(sb))
+	if err != nil {
+		return 0, false
+	}
+	return uint64(t.UnixNano()), true
+}
+
+//export plugin_extract_str
+func plugin_extract_str(plgState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32) *byte {
+	pCtx := (*pluginContext)(sinsp.Context(plgState))
+
+	var res string
+	var err error
+
+	switch {
+	case id == FieldIDStats:
+		res = pCtx.statsString()
+	case pCtx.workerPoolSize > 0:
+		res, err = pCtx.extractPooled(evtnum, id, C.GoString((*C.char)(unsafe.Pointer(arg))), C.GoString((*C.char)(unsafe.Pointer(data))))
+	default:
+		res, err = pCtx.extractSync(evtnum, id, C.GoString((*C.char)(unsafe.Pointer(arg))), C.GoString((*C.char)(unsafe.Pointer(data))))
+	}
+
+	if err != nil {
+		if err != errFieldNotFound {
+			pCtx.lastError = err
+		}
+		return nil
 	}
 
 	// NULL terminate the result so C will like it
@@ -178,13 +1080,69 @@ func plugin_extract_str(plgState unsafe.Pointer, evtnum uint64, id uint32, arg *
 	return sinsp.Buffer(plgState)
 }
 
+// plugin_extract_u64 is the uint64-typed counterpart to plugin_extract_str,
+// wired up via RegisterAsyncExtractors for fields whose plugin_get_fields
+// Type is not "string" (jevt.num, jevt.bool, jevt.time). bool and abstime
+// are both encoded as uint64, per field_present/Type convention; it's the
+// Type string in plugin_get_fields that tells the host how to interpret the
+// returned value.
+//
+//export plugin_extract_u64
+func plugin_extract_u64(plgState unsafe.Pointer, evtnum uint64, id uint32, arg *byte, data *byte, datalen uint32, field_present *uint32) uint64 {
+	pCtx := (*pluginContext)(sinsp.Context(plgState))
+
+	ev, release, err := pCtx.eventDataFor(evtnum, C.GoString((*C.char)(unsafe.Pointer(data))))
+	if err != nil {
+		*field_present = 0
+		return 0
+	}
+	defer release()
+	sarg := C.GoString((*C.char)(unsafe.Pointer(arg)))
+
+	ev.jdataMu.Lock()
+	defer ev.jdataMu.Unlock()
+
+	switch id {
+	case FieldIDNum:
+		n, ok := extractInt64At(ev.jdata, sarg)
+		if !ok {
+			*field_present = 0
+			return 0
+		}
+		*field_present = 1
+		return uint64(n)
+	case FieldIDBool:
+		b, ok := extractBoolAt(ev.jdata, sarg)
+		if !ok {
+			*field_present = 0
+			return 0
+		}
+		*field_present = 1
+		if b {
+			return 1
+		}
+		return 0
+	case FieldIDTime:
+		ns, ok := extractTimeAt(ev.jdata, sarg)
+		if !ok {
+			*field_present = 0
+			return 0
+		}
+		*field_present = 1
+		return ns
+	default:
+		*field_present = 0
+		return 0
+	}
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // The following code is part of the plugin interface. Do not remove it.
 ///////////////////////////////////////////////////////////////////////////////
 
 //export plugin_register_async_extractor
 func plugin_register_async_extractor(pluginState unsafe.Pointer, asyncExtractorInfo unsafe.Pointer) int32 {
-	return sinsp.RegisterAsyncExtractors(pluginState, asyncExtractorInfo, plugin_extract_str, nil)
+	return sinsp.RegisterAsyncExtractors(pluginState, asyncExtractorInfo, plugin_extract_str, plugin_extract_u64)
 }
 
 func main() {
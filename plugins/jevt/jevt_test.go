@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/valyala/fastjson"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Regression test for a nested CBOR object: the default cbor.Unmarshal target
+// decodes maps as map[interface{}]interface{}, which encoding/json refuses
+// to marshal. cborDecMode must avoid that.
+func TestDecodeEventCBORNestedObject(t *testing.T) {
+	payload := map[string]interface{}{
+		"requestObject": map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "foo", "image": "busybox"},
+				},
+			},
+		},
+		"code": int64(200),
+	}
+
+	raw, err := cbor.Marshal(payload)
+	if err != nil {
+		t.Fatalf("cbor.Marshal: %v", err)
+	}
+
+	pCtx := &pluginContext{format: formatCBOR}
+	dec, err := pCtx.decodeEvent(raw)
+	if err != nil {
+		t.Fatalf("decodeEvent: %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(dec.json), &got); err != nil {
+		t.Fatalf("decodeEvent produced invalid JSON %q: %v", dec.json, err)
+	}
+	if got["code"].(float64) != 200 {
+		t.Fatalf("code = %v, want 200", got["code"])
+	}
+}
+
+// format: "ndjson" splits the buffer into its individual records, exposing
+// line 0 as the default document and every line via jevt.line[N].
+func TestDecodeEventNDJSON(t *testing.T) {
+	pCtx := &pluginContext{format: formatNDJSON}
+	dec, err := pCtx.decodeEvent([]byte("{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n"))
+	if err != nil {
+		t.Fatalf("decodeEvent: %v", err)
+	}
+	if dec.json != `{"a":1}` {
+		t.Fatalf("json (line 0) = %q, want {\"a\":1}", dec.json)
+	}
+	if len(dec.lines) != 3 || dec.lines[2] != `{"a":3}` {
+		t.Fatalf("lines = %v, want 3 entries ending in {\"a\":3}", dec.lines)
+	}
+}
+
+// format: "msgpack" must decode into plain JSON, nested objects included.
+func TestDecodeEventMessagePack(t *testing.T) {
+	payload := map[string]interface{}{
+		"requestObject": map[string]interface{}{"code": int64(500)},
+	}
+	raw, err := msgpack.Marshal(payload)
+	if err != nil {
+		t.Fatalf("msgpack.Marshal: %v", err)
+	}
+
+	pCtx := &pluginContext{format: formatMsgPack}
+	dec, err := pCtx.decodeEvent(raw)
+	if err != nil {
+		t.Fatalf("decodeEvent: %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(dec.json), &got); err != nil {
+		t.Fatalf("decodeEvent produced invalid JSON %q: %v", dec.json, err)
+	}
+	reqObj := got["requestObject"].(map[string]interface{})
+	if reqObj["code"].(float64) != 500 {
+		t.Fatalf("code = %v, want 500", reqObj["code"])
+	}
+}
+
+// jevt.jsonpath and jevt.jmespath must extract the same value whether or not
+// the expression was already compiled and cached from an earlier event.
+func TestExtractFieldJSONPathJMESPathCache(t *testing.T) {
+	pCtx := &pluginContext{
+		jsonpathCache: newExprCache(compiledExprCacheSize),
+		jmespathCache: newExprCache(compiledExprCacheSize),
+	}
+
+	payload := `{"requestObject":{"spec":{"containers":[{"name":"foo","image":"busybox"},{"name":"bar","image":"nginx"}]}}}`
+	var jdata fastjson.Parser
+	v, err := jdata.Parse(payload)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	ev := eventData{jdata: v, jdataMu: &sync.Mutex{}}
+
+	for i := 0; i < 2; i++ {
+		got, err := pCtx.extractField(ev, FieldIDJSONPath, "$.requestObject.spec.containers[?(@.image=='busybox')].name")
+		if err != nil {
+			t.Fatalf("round %d: extractField(jsonpath): %v", i, err)
+		}
+		if got != "foo" {
+			t.Fatalf("round %d: jsonpath = %q, want foo", i, got)
+		}
+
+		got, err = pCtx.extractField(ev, FieldIDJMESPath, "requestObject.spec.containers[1].name")
+		if err != nil {
+			t.Fatalf("round %d: extractField(jmespath): %v", i, err)
+		}
+		if got != "bar" {
+			t.Fatalf("round %d: jmespath = %q, want bar", i, got)
+		}
+	}
+}
+
+// Concurrent cachedEventData misses for the same evtnum must collapse into a
+// single decode+parse rather than racing each other.
+func TestCachedEventDataCollapsesConcurrentMisses(t *testing.T) {
+	pCtx := &pluginContext{
+		jdataCache: newExprCache(4),
+		jdataGroup: newEventDataGroup(),
+	}
+	pCtx.jdataCache.onEvict = func(_ string, value interface{}) {
+		value.(*jdataCacheEntry).evict(&pCtx.parserPool)
+	}
+	pCtx.parserPool.New = func() interface{} { return &fastjson.Parser{} }
+
+	const n = 32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			<-start
+			ev, release, err := pCtx.cachedEventData(1, `{"a":"hello"}`)
+			if err != nil {
+				t.Errorf("cachedEventData: %v", err)
+				return
+			}
+			defer release()
+			val, err := pCtx.extractField(ev, FieldIDValue, "/a")
+			if err != nil {
+				t.Errorf("extractField: %v", err)
+				return
+			}
+			if val != "hello" {
+				t.Errorf("a = %q, want hello", val)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&pCtx.stats.parseCount); got != 1 {
+		t.Fatalf("parseCount = %d, want 1", got)
+	}
+}
+
+// extractPooled must fall back to a synchronous extraction, without
+// blocking, when the worker pool's job queue is saturated.
+func TestExtractPooledFallbackOnSaturation(t *testing.T) {
+	pCtx := &pluginContext{
+		workerPoolSize: 1,
+		jobs:           make(chan *extractJob), // unbuffered, nothing drains it
+		jdataCache:     newExprCache(4),
+		jdataGroup:     newEventDataGroup(),
+	}
+	pCtx.jdataCache.onEvict = func(_ string, value interface{}) {
+		value.(*jdataCacheEntry).evict(&pCtx.parserPool)
+	}
+	pCtx.parserPool.New = func() interface{} { return &fastjson.Parser{} }
+
+	res, err := pCtx.extractPooled(1, FieldIDValue, "/a", `{"a":"hello"}`)
+	if err != nil {
+		t.Fatalf("extractPooled: %v", err)
+	}
+	if res != "hello" {
+		t.Fatalf("result = %q, want hello", res)
+	}
+	if got := atomic.LoadInt64(&pCtx.stats.dropped); got != 1 {
+		t.Fatalf("dropped = %d, want 1", got)
+	}
+}
+
+// jdataCache's capacity (worker_pool_size*4) is far smaller than the number
+// of distinct evtnums below, so every worker churns through evictions while
+// others are still reading already-cached entries. A jdataCacheEntry whose
+// parser gets recycled while still being read would surface here as
+// go test -race flagging a concurrent read/write on the same
+// *fastjson.Parser, or as a extractField miss on a field that's actually
+// present.
+func TestExtractPooledNoCorruptionUnderEvictionPressure(t *testing.T) {
+	pCtx := &pluginContext{}
+	pCtx.startWorkerPool(2) // jdataCache capacity = 2*4 = 8
+
+	const evtnums = 32 // well past cache capacity, to force steady eviction
+	var wg sync.WaitGroup
+	wg.Add(evtnums)
+	for i := 0; i < evtnums; i++ {
+		evtnum := uint64(i)
+		payload := fmt.Sprintf(`{"a":"val-%d"}`, i)
+		want := fmt.Sprintf("val-%d", i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				val, err := pCtx.extractPooled(evtnum, FieldIDValue, "/a", payload)
+				if err != nil {
+					t.Errorf("evtnum %d: extractPooled: %v", evtnum, err)
+					return
+				}
+				if val != want {
+					t.Errorf("evtnum %d: extractPooled = %q, want %q", evtnum, val, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+const containerSchema = `{
+	"type": "object",
+	"required": ["spec"],
+	"properties": {
+		"spec": {
+			"type": "object",
+			"properties": {
+				"containers": {
+					"type": "array",
+					"items": {
+						"type": "object",
+						"required": ["name", "image"],
+						"properties": {
+							"name": {"type": "string"},
+							"image": {"type": "string"}
+						}
+					}
+				}
+			}
+		}
+	}
+}`
+
+func parseJSON(t *testing.T, s string) *fastjson.Value {
+	t.Helper()
+	var p fastjson.Parser
+	v, err := p.Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return v
+}
+
+// With no schema configured, every event is trivially valid.
+func TestValidateSchemaNoSchemaConfigured(t *testing.T) {
+	pCtx := &pluginContext{}
+	valid, errs := pCtx.validateSchema(parseJSON(t, `{"anything":"goes"}`))
+	if !valid || errs != nil {
+		t.Fatalf("valid, errs = %v, %v, want true, nil", valid, errs)
+	}
+}
+
+func TestValidateSchemaValidDocument(t *testing.T) {
+	schema, err := loadSchema(pluginConfig{Schema: containerSchema})
+	if err != nil {
+		t.Fatalf("loadSchema: %v", err)
+	}
+	pCtx := &pluginContext{schema: schema}
+
+	valid, errs := pCtx.validateSchema(parseJSON(t, `{"spec":{"containers":[{"name":"foo","image":"busybox"}]}}`))
+	if !valid || errs != nil {
+		t.Fatalf("valid, errs = %v, %v, want true, nil", valid, errs)
+	}
+}
+
+// Nested jsonschema.ValidationError.Causes must flatten into one first-error
+// message per JSON Pointer.
+func TestValidateSchemaInvalidDocumentFlattensNestedCauses(t *testing.T) {
+	schema, err := loadSchema(pluginConfig{Schema: containerSchema})
+	if err != nil {
+		t.Fatalf("loadSchema: %v", err)
+	}
+	pCtx := &pluginContext{schema: schema}
+
+	valid, errs := pCtx.validateSchema(parseJSON(t, `{"spec":{"containers":[{"name":123}]}}`))
+	if valid {
+		t.Fatalf("valid = true, want false")
+	}
+	if _, ok := errs["/spec/containers/0"]; !ok {
+		t.Fatalf("errs = %v, want an entry for /spec/containers/0", errs)
+	}
+	if _, ok := errs["/spec/containers/0/name"]; !ok {
+		t.Fatalf("errs = %v, want an entry for /spec/containers/0/name", errs)
+	}
+}
+
+func TestExtractInt64At(t *testing.T) {
+	jdata := parseJSON(t, `{"response":{"code":404},"note":"text"}`)
+
+	if n, ok := extractInt64At(jdata, "/response/code"); !ok || n != 404 {
+		t.Fatalf("extractInt64At(/response/code) = %d, %v, want 404, true", n, ok)
+	}
+	if _, ok := extractInt64At(jdata, "/response/missing"); ok {
+		t.Fatalf("extractInt64At(/response/missing) ok = true, want false")
+	}
+	if _, ok := extractInt64At(jdata, "/note"); ok {
+		t.Fatalf("extractInt64At(/note) ok = true, want false (not a number)")
+	}
+}
+
+func TestExtractBoolAt(t *testing.T) {
+	jdata := parseJSON(t, `{"a":true,"b":false,"c":"nope"}`)
+
+	if b, ok := extractBoolAt(jdata, "/a"); !ok || !b {
+		t.Fatalf("extractBoolAt(/a) = %v, %v, want true, true", b, ok)
+	}
+	if b, ok := extractBoolAt(jdata, "/b"); !ok || b {
+		t.Fatalf("extractBoolAt(/b) = %v, %v, want false, true", b, ok)
+	}
+	if _, ok := extractBoolAt(jdata, "/c"); ok {
+		t.Fatalf("extractBoolAt(/c) ok = true, want false (not a bool)")
+	}
+	if _, ok := extractBoolAt(jdata, "/missing"); ok {
+		t.Fatalf("extractBoolAt(/missing) ok = true, want false")
+	}
+}
+
+func TestExtractTimeAt(t *testing.T) {
+	jdata := parseJSON(t, `{"at":"2024-01-02T03:04:05Z","bad":"not-a-time"}`)
+
+	want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()
+	if ns, ok := extractTimeAt(jdata, "/at"); !ok || ns != uint64(want) {
+		t.Fatalf("extractTimeAt(/at) = %d, %v, want %d, true", ns, ok, want)
+	}
+	if _, ok := extractTimeAt(jdata, "/bad"); ok {
+		t.Fatalf("extractTimeAt(/bad) ok = true, want false (not RFC3339)")
+	}
+	if _, ok := extractTimeAt(jdata, "/missing"); ok {
+		t.Fatalf("extractTimeAt(/missing) ok = true, want false")
+	}
+}
+
+// plugin_extract_u64 resolves jevt.bool through eventDataFor and
+// extractBoolAt; exercise those two directly, for both a present and a
+// missing field, since the cgo export itself (and its 0/1 packing and
+// field_present handling) can't be called from a plain Go test.
+func TestEventDataForBoolFieldPresent(t *testing.T) {
+	pCtx := &pluginContext{}
+
+	ev, release, err := pCtx.eventDataFor(1, `{"ready":true}`)
+	if err != nil {
+		t.Fatalf("eventDataFor: %v", err)
+	}
+	defer release()
+
+	b, ok := extractBoolAt(ev.jdata, "/ready")
+	if !ok || !b {
+		t.Fatalf("extractBoolAt(/ready) = %v, %v, want true, true", b, ok)
+	}
+	if _, ok := extractBoolAt(ev.jdata, "/missing"); ok {
+		t.Fatalf("extractBoolAt(/missing) ok = true, want false")
+	}
+}